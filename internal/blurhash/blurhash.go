@@ -0,0 +1,196 @@
+// Package blurhash computes the compact BlurHash placeholder strings used
+// by Mastodon and GoToSocial to render an image's rough shape and colour
+// before the real thumbnail has loaded.
+// See https://github.com/woltapp/blurhash for the reference algorithm.
+package blurhash
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// maxSampleDim bounds the side length the source is downsampled to before
+// the DCT runs; BlurHash only encodes a handful of low-frequency components,
+// so sampling a full-size thumbnail would be wasted work.
+const maxSampleDim = 32
+
+const base83Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// ComponentsX and ComponentsY are the number of DCT components sampled
+// along each axis, matching the reference implementation's 4x3 default.
+const (
+	ComponentsX = 4
+	ComponentsY = 3
+)
+
+// Encode computes the BlurHash string for img.
+func Encode(img image.Image) (string, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return "", fmt.Errorf("blurhash: empty image")
+	}
+	img = downsample(img)
+
+	factors := make([][3]float64, 0, ComponentsX*ComponentsY)
+	for cy := 0; cy < ComponentsY; cy++ {
+		for cx := 0; cx < ComponentsX; cx++ {
+			factors = append(factors, dctComponent(img, cx, cy))
+		}
+	}
+
+	dc := factors[0]
+	ac := factors[1:]
+
+	maxAC := 0.0
+	for _, c := range ac {
+		for _, v := range c {
+			if math.Abs(v) > maxAC {
+				maxAC = math.Abs(v)
+			}
+		}
+	}
+
+	out := make([]byte, 0, 4+2*len(ac))
+	sizeFlag := (ComponentsX - 1) + (ComponentsY-1)*9
+	out = append(out, encodeBase83(sizeFlag, 1)...)
+
+	if len(ac) > 0 {
+		quantMax := int(math.Floor(math.Max(0, math.Min(82, math.Floor(maxAC*166-0.5)))))
+		out = append(out, encodeBase83(quantMax, 1)...)
+	} else {
+		out = append(out, encodeBase83(0, 1)...)
+	}
+
+	out = append(out, encodeBase83(encodeDC(dc), 4)...)
+
+	maxValue := 1.0
+	if len(ac) > 0 {
+		maxValue = float64(int(math.Floor(math.Max(0, math.Min(82, math.Floor(maxAC*166-0.5)))))+1) / 166
+	}
+	for _, c := range ac {
+		out = append(out, encodeBase83(encodeAC(c, maxValue), 2)...)
+	}
+
+	return string(out), nil
+}
+
+// downsample shrinks img to at most maxSampleDim on its longest side,
+// since BlurHash only needs a handful of low-frequency samples.
+func downsample(img image.Image) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSampleDim && height <= maxSampleDim {
+		return img
+	}
+
+	scale := float64(maxSampleDim) / math.Max(float64(width), float64(height))
+	dstWidth := int(math.Max(1, math.Round(float64(width)*scale)))
+	dstHeight := int(math.Max(1, math.Round(float64(height)*scale)))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// dctComponent computes the (cx, cy) basis function's weighted average
+// colour over img, i.e. a single DC or AC coefficient.
+func dctComponent(img image.Image, cx, cy int) [3]float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	var r, g, b, total float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			basis := math.Cos(math.Pi*float64(cx)*float64(x)/float64(width)) *
+				math.Cos(math.Pi*float64(cy)*float64(y)/float64(height))
+
+			pr, pg, pb, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			r += basis * sRGBToLinear(float64(pr)/65535)
+			g += basis * sRGBToLinear(float64(pg)/65535)
+			b += basis * sRGBToLinear(float64(pb)/65535)
+			total++
+		}
+	}
+
+	scale := 1.0
+	if cx != 0 || cy != 0 {
+		scale = 2.0
+	}
+	scale /= total
+
+	return [3]float64{r * scale, g * scale, b * scale}
+}
+
+func encodeDC(c [3]float64) int {
+	r := linearToSRGBByte(c[0])
+	g := linearToSRGBByte(c[1])
+	b := linearToSRGBByte(c[2])
+	return (r << 16) + (g << 8) + b
+}
+
+func encodeAC(c [3]float64, maxValue float64) int {
+	quant := func(v float64) int {
+		q := int(math.Floor(signPow(v/maxValue, 0.5)*9 + 9.5))
+		if q < 0 {
+			return 0
+		}
+		if q > 18 {
+			return 18
+		}
+		return q
+	}
+	return quant(c[0])*19*19 + quant(c[1])*19 + quant(c[2])
+}
+
+func signPow(v, exp float64) float64 {
+	if v < 0 {
+		return -math.Pow(-v, exp)
+	}
+	return math.Pow(v, exp)
+}
+
+func sRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGBByte(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	var s float64
+	if v <= 0.0031308 {
+		s = v * 12.92
+	} else {
+		s = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	n := int(math.Round(s * 255))
+	if n < 0 {
+		return 0
+	}
+	if n > 255 {
+		return 255
+	}
+	return n
+}
+
+func encodeBase83(value, length int) string {
+	out := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / pow83(length-i)) % 83
+		out[i-1] = base83Alphabet[digit]
+	}
+	return string(out)
+}
+
+func pow83(n int) int {
+	p := 1
+	for i := 0; i < n; i++ {
+		p *= 83
+	}
+	return p
+}
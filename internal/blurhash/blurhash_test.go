@@ -0,0 +1,56 @@
+package blurhash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeLength(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 32), G: uint8(y * 32), B: 128, A: 255})
+		}
+	}
+
+	hash, err := Encode(img)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	// 1 size-flag char + 1 quant-max char + 4 DC chars + 2 chars per AC
+	// component, for ComponentsX*ComponentsY-1 AC components.
+	want := 1 + 1 + 4 + 2*(ComponentsX*ComponentsY-1)
+	if len(hash) != want {
+		t.Errorf("len(hash) = %d, want %d (hash %q)", len(hash), want, hash)
+	}
+}
+
+func TestEncodeEmptyImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 0, 0))
+	if _, err := Encode(img); err == nil {
+		t.Errorf("Encode of an empty image: got nil error, want one")
+	}
+}
+
+func TestEncodeDeterministic(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+
+	a, err := Encode(img)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	b, err := Encode(img)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if a != b {
+		t.Errorf("Encode is not deterministic: %q != %q", a, b)
+	}
+}
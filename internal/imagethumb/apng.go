@@ -0,0 +1,295 @@
+package imagethumb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+)
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+// pngChunk is one chunk of a PNG/APNG file's chunk stream.
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// pngChunks walks the chunk stream of a PNG file, discarding CRCs.
+func pngChunks(data []byte) ([]pngChunk, error) {
+	if len(data) < 8 || !bytes.Equal(data[:8], pngSignature) {
+		return nil, fmt.Errorf("imagethumb: not a PNG")
+	}
+
+	var chunks []pngChunk
+	for pos := 8; pos+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		typ := string(data[pos+4 : pos+8])
+		start := pos + 8
+		end := start + length
+		if length < 0 || end+4 > len(data) {
+			break
+		}
+		chunks = append(chunks, pngChunk{typ: typ, data: data[start:end]})
+		pos = end + 4 // skip the trailing CRC
+	}
+	return chunks, nil
+}
+
+// writePNGChunk appends a length-prefixed, CRC-suffixed chunk.
+func writePNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	buf.Write(length[:])
+	buf.WriteString(typ)
+	buf.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	buf.Write(sum[:])
+}
+
+// isAPNG reports whether data is a PNG carrying an "acTL" chunk, i.e. an
+// animated PNG rather than a plain still.
+func isAPNG(data []byte) bool {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return false
+	}
+	for _, c := range chunks {
+		if c.typ == "acTL" {
+			return true
+		}
+	}
+	return false
+}
+
+// apngFrame is one decoded fcTL/IDAT-or-fdAT pairing of an animated PNG.
+type apngFrame struct {
+	width, height, xOff, yOff int
+	delayMs                   int
+	png                       []byte // a standalone PNG rebuilt from this frame's data
+}
+
+// decodeAPNGFrames splits an animated PNG into its individual frames, each
+// rebuilt as a standalone PNG so the stdlib decoder can read it, since
+// image/png doesn't understand acTL/fcTL/fdAT chunks itself. canvasW/canvasH
+// are the full animation canvas size from IHDR, which individual frames -
+// via fcTL's own width/height/x_offset/y_offset - may only partially cover.
+func decodeAPNGFrames(data []byte) (frames []apngFrame, numPlays, canvasW, canvasH int, err error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+
+	var ihdr, plte, trns []byte
+	var cur *apngFrame
+	var curData []byte
+
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		cur.png = buildPNG(ihdr, cur.width, cur.height, plte, trns, curData)
+		frames = append(frames, *cur)
+	}
+
+	for _, c := range chunks {
+		switch c.typ {
+		case "IHDR":
+			ihdr = c.data
+			if len(ihdr) >= 8 {
+				canvasW = int(binary.BigEndian.Uint32(ihdr[0:4]))
+				canvasH = int(binary.BigEndian.Uint32(ihdr[4:8]))
+			}
+		case "PLTE":
+			plte = c.data
+		case "tRNS":
+			trns = c.data
+		case "acTL":
+			if len(c.data) >= 8 {
+				numPlays = int(binary.BigEndian.Uint32(c.data[4:8]))
+			}
+		case "fcTL":
+			flush()
+			if len(c.data) < 26 {
+				cur = nil
+				continue
+			}
+			delayNum := int(binary.BigEndian.Uint16(c.data[20:22]))
+			delayDen := int(binary.BigEndian.Uint16(c.data[22:24]))
+			if delayDen == 0 {
+				delayDen = 100
+			}
+			cur = &apngFrame{
+				width:   int(binary.BigEndian.Uint32(c.data[4:8])),
+				height:  int(binary.BigEndian.Uint32(c.data[8:12])),
+				xOff:    int(binary.BigEndian.Uint32(c.data[12:16])),
+				yOff:    int(binary.BigEndian.Uint32(c.data[16:20])),
+				delayMs: delayNum * 1000 / delayDen,
+			}
+			curData = nil
+		case "IDAT":
+			curData = append(curData, c.data...)
+		case "fdAT":
+			if len(c.data) > 4 {
+				curData = append(curData, c.data[4:]...) // drop the sequence number
+			}
+		}
+	}
+	flush()
+
+	if len(frames) == 0 {
+		return nil, 0, 0, 0, fmt.Errorf("imagethumb: no APNG frames found")
+	}
+	return frames, numPlays, canvasW, canvasH, nil
+}
+
+// buildPNG reassembles a single APNG frame's image data into a standalone
+// PNG byte stream, reusing the source's IHDR bit depth/colour type/etc but
+// the frame's own dimensions.
+func buildPNG(ihdr []byte, width, height int, plte, trns, idat []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+
+	newIHDR := make([]byte, 13)
+	binary.BigEndian.PutUint32(newIHDR[0:4], uint32(width))
+	binary.BigEndian.PutUint32(newIHDR[4:8], uint32(height))
+	if len(ihdr) >= 13 {
+		copy(newIHDR[8:13], ihdr[8:13])
+	}
+	writePNGChunk(&buf, "IHDR", newIHDR)
+
+	if plte != nil {
+		writePNGChunk(&buf, "PLTE", plte)
+	}
+	if trns != nil {
+		writePNGChunk(&buf, "tRNS", trns)
+	}
+	writePNGChunk(&buf, "IDAT", idat)
+	writePNGChunk(&buf, "IEND", nil)
+
+	return buf.Bytes()
+}
+
+// thumbnailAPNG resizes every frame of an animated PNG, keeping per-frame
+// delays and the loop count, the same way thumbnailGIF does for GIF.
+func thumbnailAPNG(data []byte, width int) ([]byte, error) {
+	frames, numPlays, canvasW, canvasH, err := decodeAPNGFrames(data)
+	if err != nil {
+		return nil, fmt.Errorf("imagethumb: decode apng: %w", err)
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, canvasW, canvasH))
+	resized := make([]*image.RGBA, len(frames))
+	delays := make([]int, len(frames))
+
+	for i, f := range frames {
+		img, err := png.Decode(bytes.NewReader(f.png))
+		if err != nil {
+			return nil, fmt.Errorf("imagethumb: decode apng frame %d: %w", i, err)
+		}
+		draw.Draw(canvas, image.Rect(f.xOff, f.yOff, f.xOff+f.width, f.yOff+f.height), img, image.Point{}, draw.Over)
+		resized[i] = resize(canvas, width)
+		delays[i] = f.delayMs
+	}
+
+	return encodeAPNG(resized, delays, numPlays)
+}
+
+// alwaysAlpha forces png.Encode to always choose a colour type with an
+// alpha channel, regardless of whether the particular frame happens to be
+// fully opaque. Without this, png.Encode narrows opaque RGBA frames to
+// truecolor-without-alpha, so frames could end up with different colour
+// types/bit depths - but encodeAPNG reuses frame 0's IHDR as the shared
+// header for every frame's IDAT/fdAT data, so all frames must share one
+// encoding.
+type alwaysAlpha struct{ *image.RGBA }
+
+func (alwaysAlpha) Opaque() bool { return false }
+
+// encodeAPNG wraps stdlib-encoded PNG frames in acTL/fcTL/fdAT chunks to
+// produce an animated PNG, using the first frame's encoder output for the
+// shared IHDR/PLTE chunks.
+func encodeAPNG(frames []*image.RGBA, delaysMs []int, numPlays int) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("imagethumb: no frames to encode")
+	}
+
+	var first bytes.Buffer
+	if err := png.Encode(&first, alwaysAlpha{frames[0]}); err != nil {
+		return nil, fmt.Errorf("imagethumb: encode apng frame 0: %w", err)
+	}
+	chunks, err := pngChunks(first.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+
+	seq := 0
+	for _, c := range chunks {
+		switch c.typ {
+		case "IHDR":
+			writePNGChunk(&out, "IHDR", c.data)
+
+			actl := make([]byte, 8)
+			binary.BigEndian.PutUint32(actl[0:4], uint32(len(frames)))
+			binary.BigEndian.PutUint32(actl[4:8], uint32(numPlays))
+			writePNGChunk(&out, "acTL", actl)
+
+			writePNGChunk(&out, "fcTL", fcTLData(seq, frames[0].Bounds(), delaysMs[0]))
+			seq++
+		case "IEND":
+			for i := 1; i < len(frames); i++ {
+				var fb bytes.Buffer
+				if err := png.Encode(&fb, alwaysAlpha{frames[i]}); err != nil {
+					return nil, fmt.Errorf("imagethumb: encode apng frame %d: %w", i, err)
+				}
+				fchunks, err := pngChunks(fb.Bytes())
+				if err != nil {
+					return nil, err
+				}
+
+				writePNGChunk(&out, "fcTL", fcTLData(seq, frames[i].Bounds(), delaysMs[i]))
+				seq++
+
+				for _, fc := range fchunks {
+					if fc.typ != "IDAT" {
+						continue
+					}
+					fdat := make([]byte, 4+len(fc.data))
+					binary.BigEndian.PutUint32(fdat[0:4], uint32(seq))
+					seq++
+					copy(fdat[4:], fc.data)
+					writePNGChunk(&out, "fdAT", fdat)
+				}
+			}
+			writePNGChunk(&out, "IEND", nil)
+		default:
+			writePNGChunk(&out, c.typ, c.data)
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// fcTLData builds an fcTL chunk's payload for a frame at sequence seq.
+func fcTLData(seq int, bounds image.Rectangle, delayMs int) []byte {
+	b := make([]byte, 26)
+	binary.BigEndian.PutUint32(b[0:4], uint32(seq))
+	binary.BigEndian.PutUint32(b[4:8], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(b[8:12], uint32(bounds.Dy()))
+	binary.BigEndian.PutUint16(b[20:22], uint16(delayMs))
+	binary.BigEndian.PutUint16(b[22:24], 1000)
+	b[24] = 0 // dispose_op: none
+	b[25] = 0 // blend_op: source
+	return b
+}
@@ -0,0 +1,92 @@
+package imagethumb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestIsAPNG(t *testing.T) {
+	still := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, still); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if isAPNG(buf.Bytes()) {
+		t.Fatalf("plain PNG reported as animated")
+	}
+}
+
+func TestDecodeAPNGFrames(t *testing.T) {
+	f0 := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	f1 := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	fillColor(f1, color.RGBA{255, 0, 0, 255})
+
+	apng, err := encodeAPNG([]*image.RGBA{f0, f1}, []int{100, 200}, 0)
+	if err != nil {
+		t.Fatalf("encodeAPNG: %v", err)
+	}
+	if !isAPNG(apng) {
+		t.Fatalf("encoded output not detected as APNG")
+	}
+
+	frames, numPlays, canvasW, canvasH, err := decodeAPNGFrames(apng)
+	if err != nil {
+		t.Fatalf("decodeAPNGFrames: %v", err)
+	}
+	if numPlays != 0 {
+		t.Errorf("numPlays = %d, want 0 (infinite loop)", numPlays)
+	}
+	if canvasW != 4 || canvasH != 4 {
+		t.Errorf("canvas = %dx%d, want 4x4", canvasW, canvasH)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	if frames[0].delayMs != 100 || frames[1].delayMs != 200 {
+		t.Errorf("delays = %d,%d, want 100,200", frames[0].delayMs, frames[1].delayMs)
+	}
+
+	for i, f := range frames {
+		if _, err := png.Decode(bytes.NewReader(f.png)); err != nil {
+			t.Errorf("frame %d: rebuilt PNG didn't decode: %v", i, err)
+		}
+	}
+}
+
+func TestEncodeAPNGMixedOpacity(t *testing.T) {
+	// Frame 0 is fully opaque; frame 1 has a transparent region. Left to
+	// png.Encode's own judgement, these would pick different colour types,
+	// corrupting every frame but the first once reassembled under one IHDR.
+	opaque := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	fillColor(opaque, color.RGBA{0, 255, 0, 255})
+
+	translucent := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	fillColor(translucent, color.RGBA{255, 0, 0, 128})
+
+	apng, err := encodeAPNG([]*image.RGBA{opaque, translucent}, []int{100, 100}, 0)
+	if err != nil {
+		t.Fatalf("encodeAPNG: %v", err)
+	}
+
+	frames, _, _, _, err := decodeAPNGFrames(apng)
+	if err != nil {
+		t.Fatalf("decodeAPNGFrames: %v", err)
+	}
+	for i, f := range frames {
+		if _, err := png.Decode(bytes.NewReader(f.png)); err != nil {
+			t.Fatalf("frame %d: rebuilt PNG didn't decode: %v", i, err)
+		}
+	}
+}
+
+func fillColor(img *image.RGBA, c color.RGBA) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package imagethumb
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/jdeng/goheif"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// orientation reads the EXIF Orientation tag (1-8) from a source image's raw
+// bytes, defaulting to 1 (no transform) if the source carries no EXIF data
+// or the tag is missing. HEIC/HEIF containers store EXIF in a separate box
+// rather than inline, so goheif.ExtractExif is tried as a fallback.
+func orientation(data []byte) int {
+	if x, err := exif.Decode(bytes.NewReader(data)); err == nil {
+		if o, ok := orientationFromExif(x); ok {
+			return o
+		}
+	}
+
+	if raw, err := goheif.ExtractExif(bytes.NewReader(data)); err == nil {
+		if x, err := exif.Decode(bytes.NewReader(raw)); err == nil {
+			if o, ok := orientationFromExif(x); ok {
+				return o
+			}
+		}
+	}
+
+	return 1
+}
+
+func orientationFromExif(x *exif.Exif) (int, bool) {
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 0, false
+	}
+	v, err := tag.Int(0)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// applyOrientation rotates/flips img according to EXIF orientation tags 1-8,
+// so portrait photos from phone cameras aren't thumbnailed sideways.
+// See https://exiftool.org/TagNames/EXIF.html for the tag's semantics.
+func applyOrientation(img image.Image, o int) image.Image {
+	switch o {
+	case 2:
+		return flipH(img)
+	case 3:
+		return rotate180(img)
+	case 4:
+		return rotate180(flipH(img))
+	case 5:
+		return rotate270(flipH(img))
+	case 6:
+		return rotate90(img)
+	case 7:
+		return rotate90(flipH(img))
+	case 8:
+		return rotate270(img)
+	default:
+		return img
+	}
+}
+
+func flipH(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(b.Max.X-1-(x-b.Min.X), y, img.At(x, y))
+		}
+	}
+	return dst
+}
+
+func rotate180(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(b.Max.X-1-(x-b.Min.X), b.Max.Y-1-(y-b.Min.Y), img.At(x, y))
+		}
+	}
+	return dst
+}
+
+func rotate90(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(b.Max.Y-1-(y-b.Min.Y), x-b.Min.X, img.At(x, y))
+		}
+	}
+	return dst
+}
+
+func rotate270(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(y-b.Min.Y, b.Max.X-1-(x-b.Min.X), img.At(x, y))
+		}
+	}
+	return dst
+}
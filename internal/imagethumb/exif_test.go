@@ -0,0 +1,124 @@
+package imagethumb
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// quadImage builds a 2x2 image with a distinct colour in each corner, so
+// orientation transforms can be checked by reading pixels back out.
+func quadImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})   // top-left: red
+	img.SetRGBA(1, 0, color.RGBA{0, 255, 0, 255})   // top-right: green
+	img.SetRGBA(0, 1, color.RGBA{0, 0, 255, 255})   // bottom-left: blue
+	img.SetRGBA(1, 1, color.RGBA{255, 255, 0, 255}) // bottom-right: yellow
+	return img
+}
+
+func at(img image.Image, x, y int) color.RGBA {
+	r, g, b, a := img.At(x, y).RGBA()
+	return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+}
+
+func TestApplyOrientationIdentity(t *testing.T) {
+	src := quadImage()
+	out := applyOrientation(src, 1)
+	if at(out, 0, 0) != at(src, 0, 0) || at(out, 1, 1) != at(src, 1, 1) {
+		t.Errorf("orientation 1 (identity) changed the image")
+	}
+}
+
+func TestApplyOrientationRotate180(t *testing.T) {
+	src := quadImage()
+	out := applyOrientation(src, 3)
+	if at(out, 0, 0) != at(src, 1, 1) || at(out, 1, 1) != at(src, 0, 0) {
+		t.Errorf("orientation 3 (rotate 180) didn't swap opposite corners")
+	}
+}
+
+func TestApplyOrientationRotate90(t *testing.T) {
+	src := quadImage()
+	out := applyOrientation(src, 6)
+	b := out.Bounds()
+	if b.Dx() != src.Bounds().Dy() || b.Dy() != src.Bounds().Dx() {
+		t.Fatalf("orientation 6 (rotate 90) didn't swap width/height: got %v", b)
+	}
+	// The top-left source pixel (red) should land in the top-right corner
+	// after a 90-degree clockwise rotation.
+	if at(out, b.Max.X-1, 0) != at(src, 0, 0) {
+		t.Errorf("orientation 6 (rotate 90) placed the top-left pixel wrong")
+	}
+}
+
+func TestApplyOrientationFlipHorizontal(t *testing.T) {
+	src := quadImage()
+	out := applyOrientation(src, 2)
+	if at(out, 0, 0) != at(src, 1, 0) || at(out, 1, 0) != at(src, 0, 0) {
+		t.Errorf("orientation 2 (flip horizontal) didn't swap left/right")
+	}
+}
+
+func TestApplyOrientationFlipVertical(t *testing.T) {
+	src := quadImage()
+	out := applyOrientation(src, 4)
+	if at(out, 0, 0) != at(src, 0, 1) || at(out, 0, 1) != at(src, 0, 0) {
+		t.Errorf("orientation 4 (flip vertical) didn't swap top/bottom")
+	}
+}
+
+func TestApplyOrientationTranspose(t *testing.T) {
+	// Orientation 5: mirror horizontal then rotate 270 CW - a plain
+	// transpose across the main diagonal, so (x,y) lands at (y,x).
+	src := quadImage()
+	out := applyOrientation(src, 5)
+	b := out.Bounds()
+	if b.Dx() != src.Bounds().Dy() || b.Dy() != src.Bounds().Dx() {
+		t.Fatalf("orientation 5 (transpose) didn't swap width/height: got %v", b)
+	}
+	if at(out, 0, 0) != at(src, 0, 0) || at(out, 1, 0) != at(src, 0, 1) {
+		t.Errorf("orientation 5 (transpose) placed pixels wrong")
+	}
+}
+
+func TestApplyOrientationRotate90CW(t *testing.T) {
+	src := quadImage()
+	out := applyOrientation(src, 6)
+	b := out.Bounds()
+	if b.Dx() != src.Bounds().Dy() || b.Dy() != src.Bounds().Dx() {
+		t.Fatalf("orientation 6 (rotate 90) didn't swap width/height: got %v", b)
+	}
+	if at(out, b.Max.X-1, 0) != at(src, 0, 0) {
+		t.Errorf("orientation 6 (rotate 90) placed the top-left pixel wrong")
+	}
+}
+
+func TestApplyOrientationTransverse(t *testing.T) {
+	// Orientation 7: mirror horizontal then rotate 90 CW - a transpose
+	// across the anti-diagonal, so (x,y) lands at (h-1-y, w-1-x).
+	src := quadImage()
+	out := applyOrientation(src, 7)
+	b := out.Bounds()
+	if b.Dx() != src.Bounds().Dy() || b.Dy() != src.Bounds().Dx() {
+		t.Fatalf("orientation 7 (transverse) didn't swap width/height: got %v", b)
+	}
+	if at(out, b.Max.X-1, b.Max.Y-1) != at(src, 0, 0) {
+		t.Errorf("orientation 7 (transverse) placed the top-left pixel wrong")
+	}
+	if at(out, 0, 0) != at(src, 1, 1) {
+		t.Errorf("orientation 7 (transverse) placed the bottom-right pixel wrong")
+	}
+}
+
+func TestApplyOrientationRotate270CW(t *testing.T) {
+	src := quadImage()
+	out := applyOrientation(src, 8)
+	b := out.Bounds()
+	if b.Dx() != src.Bounds().Dy() || b.Dy() != src.Bounds().Dx() {
+		t.Fatalf("orientation 8 (rotate 270) didn't swap width/height: got %v", b)
+	}
+	if at(out, 0, b.Max.Y-1) != at(src, 0, 0) {
+		t.Errorf("orientation 8 (rotate 270) placed the top-left pixel wrong")
+	}
+}
@@ -0,0 +1,55 @@
+package imagethumb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+)
+
+// TestThumbnailGIFCanvasFromLogicalScreen builds a GIF whose first frame is
+// a small sub-rectangle and whose second frame fills the full logical
+// screen, then checks that resizing doesn't clip content outside frame 0's
+// bounds.
+func TestThumbnailGIFCanvasFromLogicalScreen(t *testing.T) {
+	pal := palette.WebSafe
+
+	f0 := image.NewPaletted(image.Rect(0, 0, 2, 2), pal)
+	f1 := image.NewPaletted(image.Rect(0, 0, 10, 10), pal)
+	red := uint8(pal.Index(color.RGBA{255, 0, 0, 255}))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			f1.SetColorIndex(x, y, red)
+		}
+	}
+
+	src := &gif.GIF{
+		Image:  []*image.Paletted{f0, f1},
+		Delay:  []int{10, 10},
+		Config: image.Config{ColorModel: pal, Width: 10, Height: 10},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, src); err != nil {
+		t.Fatalf("EncodeAll: %v", err)
+	}
+
+	out, err := thumbnailGIF(buf.Bytes(), 10)
+	if err != nil {
+		t.Fatalf("thumbnailGIF: %v", err)
+	}
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("DecodeAll: %v", err)
+	}
+	last := decoded.Image[len(decoded.Image)-1]
+	if last.Bounds().Dx() != 10 || last.Bounds().Dy() != 10 {
+		t.Fatalf("last frame = %v, want a 10x10 canvas, not frame 0's 2x2", last.Bounds())
+	}
+	r, g, b, _ := last.At(9, 9).RGBA()
+	if r>>8 < 200 || g>>8 > 50 || b>>8 > 50 {
+		t.Errorf("corner pixel = (%d,%d,%d), want red from frame 1, not clipped to frame 0's corner", r>>8, g>>8, b>>8)
+	}
+}
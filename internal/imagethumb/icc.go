@@ -0,0 +1,107 @@
+package imagethumb
+
+import (
+	"bytes"
+	"sort"
+)
+
+const iccProfileMarker = "ICC_PROFILE\x00"
+
+// extractICCProfile scans a JPEG's APP2 markers for an embedded ICC colour
+// profile, reassembling it from its (possibly chunked) segments per the ICC
+// spec, so it can be carried over into the thumbnail. It returns nil if data
+// isn't a JPEG or carries no profile.
+func extractICCProfile(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	type chunk struct {
+		num  byte
+		data []byte
+	}
+	var chunks []chunk
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xD8 || marker == 0xD9 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2
+			continue
+		}
+		if marker == 0xDA {
+			// Start of scan: compressed image data follows, no more markers.
+			break
+		}
+
+		segLen := int(data[pos+2])<<8 | int(data[pos+3])
+		if segLen < 2 || pos+2+segLen > len(data) {
+			break
+		}
+		payload := data[pos+4 : pos+2+segLen]
+
+		if marker == 0xE2 && len(payload) > len(iccProfileMarker)+2 &&
+			string(payload[:len(iccProfileMarker)]) == iccProfileMarker {
+			rest := payload[len(iccProfileMarker):]
+			chunks = append(chunks, chunk{num: rest[0], data: rest[2:]})
+		}
+
+		pos += 2 + segLen
+	}
+
+	if len(chunks) == 0 {
+		return nil
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].num < chunks[j].num })
+
+	var out []byte
+	for _, c := range chunks {
+		out = append(out, c.data...)
+	}
+	return out
+}
+
+// maxICCChunkBytes keeps each injected APP2 segment under JPEG's 64KB
+// segment size limit once the marker header and length bytes are counted.
+const maxICCChunkBytes = 65535 - 2 - len(iccProfileMarker) - 2
+
+// injectICCProfile splices icc into jpegData as one or more APP2 segments
+// immediately after the SOI marker, so the output keeps the source's colour
+// profile and isn't colour-shifted on wide-gamut images.
+func injectICCProfile(jpegData []byte, icc []byte) []byte {
+	if len(icc) == 0 || len(jpegData) < 2 {
+		return jpegData
+	}
+
+	numChunks := (len(icc) + maxICCChunkBytes - 1) / maxICCChunkBytes
+	if numChunks == 0 {
+		numChunks = 1
+	}
+
+	var out bytes.Buffer
+	out.Write(jpegData[:2]) // SOI
+
+	for i := 0; i < numChunks; i++ {
+		start := i * maxICCChunkBytes
+		end := start + maxICCChunkBytes
+		if end > len(icc) {
+			end = len(icc)
+		}
+		chunkData := icc[start:end]
+
+		segLen := 2 + len(iccProfileMarker) + 2 + len(chunkData)
+		out.WriteByte(0xFF)
+		out.WriteByte(0xE2)
+		out.WriteByte(byte(segLen >> 8))
+		out.WriteByte(byte(segLen))
+		out.WriteString(iccProfileMarker)
+		out.WriteByte(byte(i + 1))
+		out.WriteByte(byte(numChunks))
+		out.Write(chunkData)
+	}
+
+	out.Write(jpegData[2:])
+	return out.Bytes()
+}
@@ -0,0 +1,43 @@
+package imagethumb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestICCRoundTrip(t *testing.T) {
+	icc := bytes.Repeat([]byte("iccprofiledata"), 10)
+
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xD9} // minimal SOI + EOI
+	out := injectICCProfile(jpeg, icc)
+
+	got := extractICCProfile(out)
+	if !bytes.Equal(got, icc) {
+		t.Fatalf("round-tripped ICC profile = %d bytes, want %d bytes matching input", len(got), len(icc))
+	}
+}
+
+func TestICCRoundTripMultiChunk(t *testing.T) {
+	icc := bytes.Repeat([]byte("x"), maxICCChunkBytes*2+100)
+
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xD9}
+	out := injectICCProfile(jpeg, icc)
+
+	got := extractICCProfile(out)
+	if !bytes.Equal(got, icc) {
+		t.Fatalf("round-tripped multi-chunk ICC profile didn't match: got %d bytes, want %d", len(got), len(icc))
+	}
+}
+
+func TestExtractICCProfileNonJPEG(t *testing.T) {
+	if got := extractICCProfile([]byte("not a jpeg")); got != nil {
+		t.Errorf("extractICCProfile on non-JPEG data = %v, want nil", got)
+	}
+}
+
+func TestInjectICCProfileNoProfile(t *testing.T) {
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xD9}
+	if got := injectICCProfile(jpeg, nil); !bytes.Equal(got, jpeg) {
+		t.Errorf("injectICCProfile with no profile modified the JPEG")
+	}
+}
@@ -0,0 +1,170 @@
+// Package imagethumb implements in-process image thumbnailing as a
+// replacement for shelling out to vipsthumbnail. Stills are decoded once,
+// scaled with a Catmull-Rom kernel, and re-encoded; animated GIF/WebP/APNG
+// sources are resized frame-by-frame so the output keeps looping.
+package imagethumb
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	cwebp "github.com/chai2010/webp"
+	"github.com/jdeng/goheif"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Default WebP quality used when Options.Quality is unset; JPEG output
+// defaults to 96 instead.
+const defaultQuality = 85
+
+// Options controls how a source image is thumbnailed.
+type Options struct {
+	Format  string // Output format: "jpg", "jpeg", "png", "gif", or "webp".
+	Width   int    // Target width in pixels; height is scaled to preserve aspect ratio.
+	Quality int    // JPEG/WebP encode quality, 1-100. Defaults to 85, or 96 for "jpg"/"jpeg". Ignored for lossless formats.
+}
+
+// Thumbnail decodes src, scales it to Options.Width, and encodes the result
+// in Options.Format. Animated GIF and WebP sources are resized frame by
+// frame so the output remains an animation.
+func Thumbnail(src io.Reader, opts Options) ([]byte, error) {
+	if opts.Width <= 0 {
+		return nil, fmt.Errorf("imagethumb: width must be positive")
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("imagethumb: read source: %w", err)
+	}
+
+	quality := opts.Quality
+	if quality <= 0 {
+		quality = defaultQuality
+		if opts.Format == "jpg" || opts.Format == "jpeg" {
+			quality = 96
+		}
+	}
+
+	switch {
+	case opts.Format == "gif":
+		return thumbnailGIF(data, opts.Width)
+	case opts.Format == "webp" && isAnimatedWebP(data):
+		return thumbnailWebP(data, opts.Width, quality)
+	case opts.Format == "png" && isAPNG(data):
+		return thumbnailAPNG(data, opts.Width)
+	default:
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			// Fall back to HEIC/HEIF, then WebP, neither of which is
+			// registered with image.Decode by default.
+			if heifImg, herr := goheif.Decode(bytes.NewReader(data)); herr == nil {
+				img = heifImg
+			} else if webpImg, werr := webp.Decode(bytes.NewReader(data)); werr == nil {
+				img = webpImg
+			} else {
+				return nil, fmt.Errorf("imagethumb: decode: %w", err)
+			}
+		}
+
+		img = applyOrientation(img, orientation(data))
+
+		out, err := encodeStill(resize(img, opts.Width), opts.Format, quality)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Format == "jpg" || opts.Format == "jpeg" {
+			if icc := extractICCProfile(data); icc != nil {
+				out = injectICCProfile(out, icc)
+			}
+		}
+		return out, nil
+	}
+}
+
+// resize scales img so its width matches width, preserving aspect ratio.
+func resize(img image.Image, width int) *image.RGBA {
+	srcBounds := img.Bounds()
+	height := int(float64(srcBounds.Dy()) * float64(width) / float64(srcBounds.Dx()))
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, srcBounds, xdraw.Over, nil)
+	return dst
+}
+
+func encodeStill(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "jpg", "jpeg":
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, fmt.Errorf("imagethumb: encode jpeg: %w", err)
+		}
+	case "png":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("imagethumb: encode png: %w", err)
+		}
+	case "webp":
+		if err := cwebp.Encode(&buf, img, &cwebp.Options{Quality: float32(quality)}); err != nil {
+			return nil, fmt.Errorf("imagethumb: encode webp: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("imagethumb: unsupported output format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// thumbnailGIF resizes every frame of an animated (or still) GIF, keeping
+// delays and loop count so the output still animates.
+func thumbnailGIF(data []byte, width int) ([]byte, error) {
+	src, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("imagethumb: decode gif: %w", err)
+	}
+
+	out := &gif.GIF{
+		LoopCount:       src.LoopCount,
+		Delay:           make([]int, len(src.Image)),
+		Disposal:        make([]byte, len(src.Image)),
+		Image:           make([]*image.Paletted, len(src.Image)),
+		BackgroundIndex: src.BackgroundIndex,
+	}
+
+	// Composite each frame over a full-size canvas before resizing, since
+	// GIF frames may be partial and rely on the previous frame's disposal.
+	// The canvas must be the logical screen size (Config.Width/Height), not
+	// frame 0's bounds - frame 0 is frequently a small sub-rectangle, not
+	// the full canvas.
+	canvas := image.NewRGBA(image.Rect(0, 0, src.Config.Width, src.Config.Height))
+	for i, frame := range src.Image {
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		resized := resize(canvas, width)
+
+		pal := frame.Palette
+		if pal == nil {
+			pal = palette.WebSafe
+		}
+		paletted := image.NewPaletted(resized.Bounds(), pal)
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), resized, image.Point{})
+
+		out.Image[i] = paletted
+		out.Delay[i] = src.Delay[i]
+		if i < len(src.Disposal) {
+			out.Disposal[i] = src.Disposal[i]
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, out); err != nil {
+		return nil, fmt.Errorf("imagethumb: encode gif: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,216 @@
+package imagethumb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+
+	cwebp "github.com/chai2010/webp"
+	xwebp "golang.org/x/image/webp"
+)
+
+// isAnimatedWebP reports whether data is a WebP RIFF container carrying an
+// "ANIM" chunk, i.e. an animated (as opposed to a plain still) WebP.
+func isAnimatedWebP(data []byte) bool {
+	chunks, err := riffChunks(data)
+	if err != nil {
+		return false
+	}
+	for _, c := range chunks {
+		if c.id == "ANIM" {
+			return true
+		}
+	}
+	return false
+}
+
+// riffChunk is one top-level chunk of a WebP's RIFF container.
+type riffChunk struct {
+	id   string
+	data []byte
+}
+
+// riffChunks walks the top-level chunks of a WebP RIFF container.
+func riffChunks(data []byte) ([]riffChunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("imagethumb: not a WebP RIFF container")
+	}
+
+	var chunks []riffChunk
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		start := pos + 8
+		end := start + size
+		if size < 0 || end > len(data) {
+			break
+		}
+		chunks = append(chunks, riffChunk{id: id, data: data[start:end]})
+		pos = end
+		if size%2 == 1 {
+			pos++ // chunks are padded to an even size
+		}
+	}
+	return chunks, nil
+}
+
+// webpFrame is one decoded ANMF entry of an animated WebP: its placement on
+// the canvas, its display duration, and its still-undecoded bitstream chunks.
+type webpFrame struct {
+	x, y, width, height int
+	durationMs           int
+	bitstream            []byte // the frame's nested ALPH/VP8/VP8L chunks
+}
+
+// parseAnimatedWebP reads the VP8X canvas size, the ANIM loop count, and
+// every ANMF frame out of an animated WebP's RIFF container.
+func parseAnimatedWebP(data []byte) (frames []webpFrame, loopCount, canvasW, canvasH int, err error) {
+	chunks, err := riffChunks(data)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+
+	for _, c := range chunks {
+		switch c.id {
+		case "VP8X":
+			if len(c.data) >= 10 {
+				canvasW = 1 + (int(c.data[4]) | int(c.data[5])<<8 | int(c.data[6])<<16)
+				canvasH = 1 + (int(c.data[7]) | int(c.data[8])<<8 | int(c.data[9])<<16)
+			}
+		case "ANIM":
+			if len(c.data) >= 6 {
+				loopCount = int(c.data[4]) | int(c.data[5])<<8
+			}
+		case "ANMF":
+			if len(c.data) < 16 {
+				continue
+			}
+			frames = append(frames, webpFrame{
+				x:          2 * (int(c.data[0]) | int(c.data[1])<<8 | int(c.data[2])<<16),
+				y:          2 * (int(c.data[3]) | int(c.data[4])<<8 | int(c.data[5])<<16),
+				width:      1 + (int(c.data[6]) | int(c.data[7])<<8 | int(c.data[8])<<16),
+				height:     1 + (int(c.data[9]) | int(c.data[10])<<8 | int(c.data[11])<<16),
+				durationMs: int(c.data[12]) | int(c.data[13])<<8 | int(c.data[14])<<16,
+				bitstream:  c.data[16:],
+			})
+		}
+	}
+
+	if len(frames) == 0 {
+		return nil, 0, 0, 0, fmt.Errorf("imagethumb: no ANMF frames found")
+	}
+	return frames, loopCount, canvasW, canvasH, nil
+}
+
+// decodeWebPFrame decodes one ANMF frame's bitstream chunks by wrapping them
+// in a minimal RIFF/WEBP container so the standard decoder can read them.
+func decodeWebPFrame(bitstream []byte) (image.Image, error) {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(4+len(bitstream)))
+	buf.WriteString("WEBP")
+	buf.Write(bitstream)
+	return xwebp.Decode(&buf)
+}
+
+// thumbnailWebP resizes every frame of an animated WebP, keeping per-frame
+// delays and the loop count, the same way thumbnailGIF does for GIF.
+func thumbnailWebP(data []byte, width, quality int) ([]byte, error) {
+	frames, loopCount, canvasW, canvasH, err := parseAnimatedWebP(data)
+	if err != nil {
+		return nil, fmt.Errorf("imagethumb: decode animated webp: %w", err)
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, canvasW, canvasH))
+	resized := make([]*image.RGBA, len(frames))
+	delays := make([]int, len(frames))
+
+	for i, f := range frames {
+		img, err := decodeWebPFrame(f.bitstream)
+		if err != nil {
+			return nil, fmt.Errorf("imagethumb: decode webp frame %d: %w", i, err)
+		}
+		draw.Draw(canvas, image.Rect(f.x, f.y, f.x+f.width, f.y+f.height), img, image.Point{}, draw.Over)
+		resized[i] = resize(canvas, width)
+		delays[i] = f.durationMs
+	}
+
+	return encodeAnimatedWebP(resized, delays, loopCount, quality)
+}
+
+// encodeWebPFrame encodes img as a standalone WebP and strips the 12-byte
+// "RIFF"+size+"WEBP" header, leaving just the chunk(s) an ANMF entry nests.
+func encodeWebPFrame(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := cwebp.Encode(&buf, img, &cwebp.Options{Quality: float32(quality)}); err != nil {
+		return nil, err
+	}
+	out := buf.Bytes()
+	if len(out) < 12 || string(out[0:4]) != "RIFF" || string(out[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("imagethumb: unexpected webp encoder output")
+	}
+	return out[12:], nil
+}
+
+// encodeAnimatedWebP rebuilds a VP8X/ANIM/ANMF animated WebP container from
+// already-resized frames, mirroring gif.EncodeAll's role for thumbnailGIF.
+func encodeAnimatedWebP(frames []*image.RGBA, delaysMs []int, loopCount, quality int) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("imagethumb: no frames to encode")
+	}
+	b := frames[0].Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 // ANIM flag
+	put24 := func(dst []byte, v int) {
+		dst[0] = byte(v)
+		dst[1] = byte(v >> 8)
+		dst[2] = byte(v >> 16)
+	}
+	put24(vp8x[4:7], width-1)
+	put24(vp8x[7:10], height-1)
+
+	anim := make([]byte, 6)
+	anim[4] = byte(loopCount)
+	anim[5] = byte(loopCount >> 8)
+
+	var body bytes.Buffer
+	writeRIFFChunk(&body, "VP8X", vp8x)
+	writeRIFFChunk(&body, "ANIM", anim)
+
+	for i, frame := range frames {
+		bitstream, err := encodeWebPFrame(frame, quality)
+		if err != nil {
+			return nil, fmt.Errorf("imagethumb: encode webp frame %d: %w", i, err)
+		}
+
+		anmf := make([]byte, 16)
+		put24(anmf[0:3], 0)
+		put24(anmf[3:6], 0)
+		put24(anmf[6:9], width-1)
+		put24(anmf[9:12], height-1)
+		put24(anmf[12:15], delaysMs[i])
+		anmf = append(anmf, bitstream...)
+		writeRIFFChunk(&body, "ANMF", anmf)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(4+body.Len()))
+	out.WriteString("WEBP")
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
+
+// writeRIFFChunk appends a FourCC-prefixed, size-prefixed, even-padded chunk.
+func writeRIFFChunk(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
@@ -0,0 +1,60 @@
+package imagethumb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIsAnimatedWebP(t *testing.T) {
+	var still bytes.Buffer
+	still.WriteString("RIFF")
+	still.Write([]byte{12, 0, 0, 0})
+	still.WriteString("WEBP")
+	still.WriteString("VP8 ")
+	still.Write([]byte{0, 0, 0, 0})
+	if isAnimatedWebP(still.Bytes()) {
+		t.Fatalf("still webp reported as animated")
+	}
+
+	var anim bytes.Buffer
+	var body bytes.Buffer
+	writeRIFFChunk(&body, "ANIM", []byte{0, 0, 0, 0, 1, 0})
+	anim.WriteString("RIFF")
+	anim.Write([]byte{byte(4 + body.Len()), 0, 0, 0})
+	anim.WriteString("WEBP")
+	anim.Write(body.Bytes())
+	if !isAnimatedWebP(anim.Bytes()) {
+		t.Fatalf("animated webp not detected")
+	}
+}
+
+func TestParseAnimatedWebPRoundTrip(t *testing.T) {
+	var body bytes.Buffer
+	writeRIFFChunk(&body, "VP8X", []byte{2, 0, 0, 0, 9, 0, 0, 19, 0, 0})
+	writeRIFFChunk(&body, "ANIM", []byte{0, 0, 0, 0, 3, 0})
+
+	anmf := make([]byte, 16)
+	anmf[6], anmf[7], anmf[8] = 9, 0, 0   // width-1 = 9 -> width 10
+	anmf[9], anmf[10], anmf[11] = 19, 0, 0 // height-1 = 19 -> height 20
+	anmf[12], anmf[13], anmf[14] = 50, 0, 0
+	anmf = append(anmf, []byte("VP8 ")...)
+	anmf = append(anmf, []byte{0, 0, 0, 0}...)
+	writeRIFFChunk(&body, "ANMF", anmf)
+
+	var data bytes.Buffer
+	data.WriteString("RIFF")
+	data.Write([]byte{byte(4 + body.Len()), 0, 0, 0})
+	data.WriteString("WEBP")
+	data.Write(body.Bytes())
+
+	frames, loopCount, canvasW, canvasH, err := parseAnimatedWebP(data.Bytes())
+	if err != nil {
+		t.Fatalf("parseAnimatedWebP: %v", err)
+	}
+	if loopCount != 3 || canvasW != 10 || canvasH != 20 {
+		t.Fatalf("loopCount/canvas = %d/%dx%d, want 3/10x20", loopCount, canvasW, canvasH)
+	}
+	if len(frames) != 1 || frames[0].width != 10 || frames[0].height != 20 || frames[0].durationMs != 50 {
+		t.Fatalf("unexpected frame: %+v", frames)
+	}
+}
@@ -0,0 +1,38 @@
+// Package procguard bounds concurrency around expensive external processes
+// (ffmpeg, ffprobe) so a burst of requests can't fork-bomb the container.
+package procguard
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrBusy is returned by Semaphore.Acquire when no slot became free before
+// the wait deadline, so the caller should shed load rather than queue forever.
+var ErrBusy = errors.New("procguard: too many concurrent invocations")
+
+// Semaphore limits how many callers can hold a slot at once.
+type Semaphore chan struct{}
+
+// New returns a Semaphore allowing up to n concurrent holders.
+func New(n int) Semaphore {
+	if n < 1 {
+		n = 1
+	}
+	return make(Semaphore, n)
+}
+
+// Acquire blocks until a slot is free, ctx is cancelled, or ctx's deadline
+// passes, whichever comes first. On success it returns a release func that
+// must be called to free the slot; on failure it returns ErrBusy or ctx's error.
+func (s Semaphore) Acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case s <- struct{}{}:
+		return func() { <-s }, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrBusy
+		}
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,105 @@
+// Package thumbcache is an in-process cache that sits in front of GCS.
+// It serves repeat requests for the same thumbnail without a round-trip,
+// and uses singleflight so that a burst of concurrent requests for a
+// not-yet-generated thumbnail collapses into a single generation call.
+package thumbcache
+
+import (
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+// Entry is a cached thumbnail: its encoded bytes plus the BlurHash computed
+// alongside it, so a cache hit doesn't need to recompute either. SrcGeneration
+// records the GCS generation of the source it was built from, so a cache hit
+// can be invalidated once the source is re-uploaded.
+type Entry struct {
+	Data          []byte
+	BlurHash      string
+	SrcGeneration string
+}
+
+// Cache bounds an LRU of Entry values by both entry count and total bytes.
+type Cache struct {
+	lru      *lru.Cache[string, Entry]
+	maxBytes int64
+	mu       sync.Mutex // guards curBytes
+	curBytes int64
+	group    singleflight.Group
+}
+
+// New creates a Cache holding at most maxEntries thumbnails, evicting the
+// least recently used entry whenever that count or maxBytes would be exceeded.
+func New(maxEntries int, maxBytes int64) (*Cache, error) {
+	c := &Cache{maxBytes: maxBytes}
+
+	l, err := lru.NewWithEvict(maxEntries, func(_ string, evicted Entry) {
+		c.curBytes -= int64(len(evicted.Data))
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.lru = l
+
+	return c, nil
+}
+
+// Key builds the cache key for a thumbnail from its GCS bucket and object path.
+func Key(bucket, thumbPath string) string {
+	return bucket + "\x00" + thumbPath
+}
+
+// Get returns the cached Entry for key, if present.
+func (c *Cache) Get(key string) (Entry, bool) {
+	return c.lru.Get(key)
+}
+
+// Set stores entry under key, evicting older entries if needed to stay
+// within the byte budget.
+func (c *Cache) Set(key string, entry Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Replacing an existing key doesn't trigger the evict callback, so the
+	// old entry's bytes are subtracted here.
+	if old, ok := c.lru.Peek(key); ok {
+		c.curBytes -= int64(len(old.Data))
+	}
+	c.curBytes += int64(len(entry.Data))
+	c.lru.Add(key, entry)
+
+	for c.curBytes > c.maxBytes && c.lru.Len() > 1 {
+		c.lru.RemoveOldest()
+	}
+}
+
+// GetOrGenerate returns the cached Entry for key if present and its
+// SrcGeneration matches srcGeneration; otherwise it calls generate, caches
+// the successful result, and returns it. A cached entry whose SrcGeneration
+// has gone stale - the source was re-uploaded since it was cached - is
+// treated as a miss, so a stale in-process entry can't outlive the GCS-level
+// check that existingThumb performs on a cache miss. Concurrent calls for the
+// same key AND srcGeneration that haven't yet been satisfied share a single
+// in-flight call to generate; the srcGeneration is folded into the
+// singleflight key itself so a request for a newly-uploaded generation can't
+// be handed the result of an older generation's still-in-flight call.
+func (c *Cache) GetOrGenerate(key, srcGeneration string, generate func() (Entry, error)) (Entry, error) {
+	if entry, ok := c.Get(key); ok && entry.SrcGeneration == srcGeneration {
+		return entry, nil
+	}
+
+	v, err, _ := c.group.Do(key+"\x00"+srcGeneration, func() (interface{}, error) {
+		entry, err := generate()
+		if err != nil {
+			return Entry{}, err
+		}
+		c.Set(key, entry)
+		return entry, nil
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	return v.(Entry), nil
+}
@@ -0,0 +1,45 @@
+package thumbcache
+
+import "testing"
+
+func TestGetOrGenerateRevalidatesOnGenerationChange(t *testing.T) {
+	c, err := New(8, 1<<20)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	key := Key("bucket", "thumb/path")
+	calls := 0
+	generate := func(gen string) func() (Entry, error) {
+		return func() (Entry, error) {
+			calls++
+			return Entry{Data: []byte("v" + gen), SrcGeneration: gen}, nil
+		}
+	}
+
+	entry, err := c.GetOrGenerate(key, "1", generate("1"))
+	if err != nil {
+		t.Fatalf("GetOrGenerate: %v", err)
+	}
+	if string(entry.Data) != "v1" || calls != 1 {
+		t.Fatalf("got %q after %d calls, want v1 after 1 call", entry.Data, calls)
+	}
+
+	// Same generation: should be served from cache without calling generate again.
+	entry, err = c.GetOrGenerate(key, "1", generate("1"))
+	if err != nil {
+		t.Fatalf("GetOrGenerate: %v", err)
+	}
+	if string(entry.Data) != "v1" || calls != 1 {
+		t.Fatalf("got %q after %d calls, want cache hit (still 1 call)", entry.Data, calls)
+	}
+
+	// Source re-uploaded: a new generation must invalidate the cached entry.
+	entry, err = c.GetOrGenerate(key, "2", generate("2"))
+	if err != nil {
+		t.Fatalf("GetOrGenerate: %v", err)
+	}
+	if string(entry.Data) != "v2" || calls != 2 {
+		t.Fatalf("got %q after %d calls, want v2 after regenerating for the new generation", entry.Data, calls)
+	}
+}
@@ -0,0 +1,122 @@
+// Package videoprobe runs ffprobe ahead of ffmpeg so the handler can make
+// decisions - where to seek, whether a source is animated, whether it's
+// within size limits - based on the actual stream contents rather than the
+// URL's file extension.
+package videoprobe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Info describes the primary video stream and container probed from a source.
+type Info struct {
+	Duration   float64 // Container duration in seconds.
+	Width      int     // Pixel width of the primary video stream.
+	Height     int     // Pixel height of the primary video stream.
+	CodecName  string  // ffprobe's codec_name for the primary video stream, e.g. "vp9".
+	FormatName string  // ffprobe's (possibly comma-separated) container format name, e.g. "matroska,webm".
+	PixFmt     string  // ffprobe's pix_fmt, e.g. "yuva420p".
+	NbFrames   int     // Reported frame count; 0 if ffprobe couldn't determine it.
+	HasAlpha   bool    // True when PixFmt contains an "a", as alpha formats like "yuva420p" and "rgba" do; some formats without alpha (e.g. "gray") also match.
+	Animated   bool    // True when NbFrames is greater than 1; false when the frame count is unknown.
+}
+
+type probeOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		PixFmt    string `json:"pix_fmt"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+		NbFrames  string `json:"nb_frames"`
+	} `json:"streams"`
+	Format struct {
+		Duration   string `json:"duration"`
+		FormatName string `json:"format_name"`
+	} `json:"format"`
+}
+
+// ProbeFile runs ffprobe against a file on disk.
+func ProbeFile(ctx context.Context, path string) (Info, error) {
+	return probe(ctx, exec.CommandContext(ctx, "ffprobe", probeArgs(path)...))
+}
+
+// ProbeReader runs ffprobe against an in-memory source, piped over stdin.
+func ProbeReader(ctx context.Context, data []byte) (Info, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", probeArgs("pipe:0")...)
+	cmd.Stdin = bytes.NewReader(data)
+	return probe(ctx, cmd)
+}
+
+func probeArgs(input string) []string {
+	return []string{
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,pix_fmt,width,height,nb_frames:format=duration,format_name",
+		"-of", "json",
+		input,
+	}
+}
+
+func probe(ctx context.Context, cmd *exec.Cmd) (Info, error) {
+	out, err := cmd.Output()
+	if err != nil {
+		return Info{}, fmt.Errorf("videoprobe: ffprobe: %w", err)
+	}
+	return parseProbeOutput(out)
+}
+
+// parseProbeOutput turns ffprobe's JSON output into an Info, split out from
+// probe so the parsing logic can be exercised without running ffprobe.
+func parseProbeOutput(out []byte) (Info, error) {
+	var parsed probeOutput
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return Info{}, fmt.Errorf("videoprobe: parse ffprobe output: %w", err)
+	}
+
+	info := Info{FormatName: parsed.Format.FormatName}
+	if d, err := strconv.ParseFloat(parsed.Format.Duration, 64); err == nil {
+		info.Duration = d
+	}
+
+	for _, s := range parsed.Streams {
+		if s.CodecType != "video" {
+			continue
+		}
+		info.CodecName = s.CodecName
+		info.PixFmt = s.PixFmt
+		info.Width = s.Width
+		info.Height = s.Height
+		info.HasAlpha = strings.Contains(s.PixFmt, "a")
+		if n, err := strconv.Atoi(s.NbFrames); err == nil {
+			info.NbFrames = n
+		}
+		break
+	}
+	info.Animated = info.NbFrames > 1
+
+	return info, nil
+}
+
+// SeekOffset returns the timestamp, in seconds, ~10% into the stream -
+// enough to skip past a black or blank first frame without requiring a
+// full decode.
+func (i Info) SeekOffset() float64 {
+	return i.Duration * 0.10
+}
+
+// Demuxer returns the ffmpeg demuxer name to pass as "-f" when reading
+// from stdin, where there's no file extension for ffmpeg to sniff.
+// ffprobe's format_name is sometimes a comma-separated list of aliases
+// (e.g. "matroska,webm"); the first entry is always a valid demuxer name.
+func (i Info) Demuxer() string {
+	if idx := strings.IndexByte(i.FormatName, ','); idx != -1 {
+		return i.FormatName[:idx]
+	}
+	return i.FormatName
+}
@@ -0,0 +1,39 @@
+package videoprobe
+
+import "testing"
+
+func TestParseProbeOutputAlphaAndAnimated(t *testing.T) {
+	raw := []byte(`{
+		"streams": [{"codec_type": "video", "codec_name": "vp9", "pix_fmt": "yuva420p", "width": 320, "height": 240, "nb_frames": "45"}],
+		"format": {"duration": "4.5", "format_name": "matroska,webm"}
+	}`)
+
+	info, err := parseProbeOutput(raw)
+	if err != nil {
+		t.Fatalf("parseProbeOutput: %v", err)
+	}
+	if !info.HasAlpha {
+		t.Errorf("HasAlpha = false, want true for pix_fmt %q", info.PixFmt)
+	}
+	if !info.Animated {
+		t.Errorf("Animated = false, want true for nb_frames %d", info.NbFrames)
+	}
+}
+
+func TestParseProbeOutputSingleFrameNotAnimated(t *testing.T) {
+	raw := []byte(`{
+		"streams": [{"codec_type": "video", "codec_name": "h264", "pix_fmt": "yuv420p", "width": 320, "height": 240, "nb_frames": "1"}],
+		"format": {"duration": "0.04", "format_name": "mov,mp4"}
+	}`)
+
+	info, err := parseProbeOutput(raw)
+	if err != nil {
+		t.Fatalf("parseProbeOutput: %v", err)
+	}
+	if info.HasAlpha {
+		t.Errorf("HasAlpha = true, want false for pix_fmt %q", info.PixFmt)
+	}
+	if info.Animated {
+		t.Errorf("Animated = true, want false for nb_frames %d", info.NbFrames)
+	}
+}
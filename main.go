@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"image"
 	"io"
 	"log"
 	"net/http"
@@ -11,18 +13,96 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"golang.org/x/exp/slices"
+
+	"github.com/weirdgloop/gcf-thumb-handler/internal/blurhash"
+	"github.com/weirdgloop/gcf-thumb-handler/internal/imagethumb"
+	"github.com/weirdgloop/gcf-thumb-handler/internal/procguard"
+	"github.com/weirdgloop/gcf-thumb-handler/internal/thumbcache"
+	"github.com/weirdgloop/gcf-thumb-handler/internal/videoprobe"
 )
 
 const (
 	MEDIA_IMAGE = "image"
 	MEDIA_UNKNOWN = "unknown"
 	MEDIA_VIDEO = "video"
+
+	// Defaults for ffprobe-enforced limits; both are overridable via env vars.
+	defaultMaxVideoDuration  = 3600 // seconds
+	defaultMaxVideoDimension = 7680 // pixels, either axis
+
+	// Defaults for the in-process thumbnail cache; overridable via env vars.
+	defaultCacheMaxEntries = 512
+	defaultCacheMaxBytes   = 256 * 1024 * 1024
+
+	// srcGenerationKey is the thumbnail metadata key recording the GCS
+	// object generation of the source it was built from, so a later request
+	// can skip regeneration when the source hasn't changed.
+	srcGenerationKey = "x-source-generation"
+
+	// Defaults bounding how many ffmpeg/ffprobe and in-process imagethumb
+	// invocations may run at once, so a burst of requests can't exhaust the
+	// container's CPU or memory. Overridable via env vars.
+	defaultMaxConcurrentFFmpeg = 2
+	defaultMaxConcurrentVips   = 2
+
+	// defaultProcTimeoutSeconds bounds how long a single ffmpeg/ffprobe
+	// invocation may run once it holds its semaphore slot. In-process
+	// imagethumb calls run without a timeout.
+	defaultProcTimeoutSeconds = 60
+
+	// defaultMaxSrcBytes caps how large a source object we'll download for
+	// thumbnailing. Overridable via env var MAX_SRC_BYTES.
+	defaultMaxSrcBytes = 32 * 1024 * 1024
+
+	// defaultMaxWidth caps the requested thumbnail width. Overridable via
+	// env var MAX_WIDTH.
+	defaultMaxWidth = 4096
+
+	// defaultSemWaitSeconds bounds how long a request will wait for a free
+	// ffmpeg/imagethumb slot before giving up, so contention surfaces as a
+	// 503 instead of blocking until the client disconnects. Overridable via
+	// env var SEM_WAIT_SECONDS.
+	defaultSemWaitSeconds = 5
 )
 
+// thumbCache serves repeat thumbnail requests without a GCS round-trip and
+// coalesces concurrent requests for the same not-yet-generated thumbnail.
+var thumbCache = newThumbCache()
+
+// ffmpegSem and vipsSem bound how many ffmpeg/ffprobe invocations and
+// in-process imagethumb calls, respectively, may run concurrently.
+var (
+	ffmpegSem = procguard.New(envInt("MAX_CONCURRENT_FFMPEG", defaultMaxConcurrentFFmpeg))
+	vipsSem   = procguard.New(envInt("MAX_CONCURRENT_VIPS", defaultMaxConcurrentVips))
+)
+
+// acquireSem waits up to SEM_WAIT_SECONDS for a free slot on sem. ctx has no
+// deadline of its own at the call sites below, so without this bound,
+// contention would just block until the client disconnects - a
+// context.Canceled, not the context.DeadlineExceeded that Semaphore.Acquire
+// needs in order to report ErrBusy.
+func acquireSem(ctx context.Context, sem procguard.Semaphore) (func(), error) {
+	waitCtx, cancel := context.WithTimeout(ctx, envDuration("SEM_WAIT_SECONDS", defaultSemWaitSeconds))
+	defer cancel()
+	return sem.Acquire(waitCtx)
+}
+
+func newThumbCache() *thumbcache.Cache {
+	maxEntries := envInt("THUMB_CACHE_MAX_ENTRIES", defaultCacheMaxEntries)
+	maxBytes := int64(envInt("THUMB_CACHE_MAX_BYTES", defaultCacheMaxBytes))
+	c, err := thumbcache.New(maxEntries, maxBytes)
+	if err != nil {
+		log.Fatalf("newThumbCache: %v", err)
+	}
+	return c
+}
+
 type ThumbError struct {
 	Ctx string // Error context
 	Err error  // Error
@@ -36,14 +116,19 @@ func (e *ThumbError) IsNotFound() bool {
 	return e.Ctx == "NotFound"
 }
 
+func (e *ThumbError) IsBusy() bool {
+	return errors.Is(e.Err, procguard.ErrBusy)
+}
+
 type ThumbParams struct {
-	Bucket    string // GCS Bucket
-	FileExt   string // Source file extension
-	FilePath  string // Source file path
-	MediaType string // Source file media type
-	ThumbExt  string // Thumbnail file extension
-	ThumbPath string // Thumbnail file path
-	Width     string // Thumbnail width
+	AnimatedThumb bool   // Whether an animated (rather than still) thumbnail was requested
+	Bucket        string // GCS Bucket
+	FileExt       string // Source file extension
+	FilePath      string // Source file path
+	MediaType     string // Source file media type
+	ThumbExt      string // Thumbnail file extension
+	ThumbPath     string // Thumbnail file path
+	Width         string // Thumbnail width
 }
 
 func main() {
@@ -68,13 +153,18 @@ func paramExtract(rawURL string) (ThumbParams, error) {
 	}
 
 	// Extract GCS bucket, wiki ID, archOrTemp, filename, thumbname, and width.
-	re := regexp.MustCompile("^/([0-9a-zA-Z-_.]+)/([0-9a-zA-Z-_.]+)/thumb/((?:archive|temp)/)?([^/]*)/(([0-9]+)px-.+)$")
+	// The animated- marker, when present, immediately follows the width.
+	re := regexp.MustCompile("^/([0-9a-zA-Z-_.]+)/([0-9a-zA-Z-_.]+)/thumb/((?:archive|temp)/)?([^/]*)/(([0-9]+)px-(animated-)?.+)$")
 	m := re.FindStringSubmatch(u.Path)
 	// Bad thumb URI
 	if m == nil {
 		return ThumbParams{}, errors.New("Bad thumb URI")
 	}
 
+	// An animated thumbnail can be requested via the "animated-" URL marker
+	// or a "?animated=1" query parameter.
+	animatedThumb := m[7] != "" || u.Query().Get("animated") == "1"
+
 	// Extract source file extension.
 	s := strings.Split(strings.ToLower(m[4]), ".")
 	fileExt := ""
@@ -91,20 +181,21 @@ func paramExtract(rawURL string) (ThumbParams, error) {
 
 	// Determine media type.
 	mediaType := MEDIA_UNKNOWN
-	if slices.Contains([]string{"png", "gif", "jpg", "jpeg", "webp"}, fileExt) {
+	if slices.Contains([]string{"png", "gif", "jpg", "jpeg", "webp", "heic", "heif"}, fileExt) {
 		mediaType = MEDIA_IMAGE
 	} else if slices.Contains([]string{"mp4", "ogg", "ogv", "webm"}, fileExt) {
 		mediaType = MEDIA_VIDEO
 	}
 
 	return ThumbParams{
-		Bucket:    m[1],
-		FileExt:   fileExt,
-		FilePath:  m[2] + "/" + m[3] + m[4],
-		MediaType: mediaType,
-		ThumbExt:  thumbExt,
-		ThumbPath: m[2] + "/thumb/" + m[3] + m[4] + "/" + m[5],
-		Width:     m[6],
+		AnimatedThumb: animatedThumb,
+		Bucket:        m[1],
+		FileExt:       fileExt,
+		FilePath:      m[2] + "/" + m[3] + m[4],
+		MediaType:     mediaType,
+		ThumbExt:      thumbExt,
+		ThumbPath:     m[2] + "/thumb/" + m[3] + m[4] + "/" + m[5],
+		Width:         m[6],
 	}, nil
 }
 
@@ -113,10 +204,33 @@ func paramValidate(params ThumbParams) (error) {
 	if params.MediaType == MEDIA_UNKNOWN {
 		return errors.New("Unsupported source file extension")
 	}
-	// Videos are only thumbnailed as JPGs.
+	// Reject implausibly large requested widths before any work is done.
+	width, err := strconv.Atoi(params.Width)
+	if err != nil {
+		return errors.New("Bad thumbnail width")
+	}
+	if maxWidth := envInt("MAX_WIDTH", defaultMaxWidth); width > maxWidth {
+		return fmt.Errorf("Requested width %d exceeds limit of %d", width, maxWidth)
+	}
+	// An animated thumbnail of a video is only produced as a looping GIF or WebP.
+	if params.MediaType == MEDIA_VIDEO && params.AnimatedThumb {
+		if params.ThumbExt == "gif" || params.ThumbExt == "webp" {
+			return nil
+		}
+		return errors.New("Unsupported animated thumbnail file extension")
+	}
+	// Videos are otherwise only thumbnailed as JPGs.
 	if params.MediaType == MEDIA_VIDEO && params.ThumbExt == "jpg" {
 		return nil
 	}
+	// HEIC/HEIF has no encoder, pure-Go or otherwise, wired up in imagethumb,
+	// so those sources are always thumbnailed as JPGs instead.
+	if params.FileExt == "heic" || params.FileExt == "heif" {
+		if params.ThumbExt == "jpg" {
+			return nil
+		}
+		return errors.New("Unsupported thumbnail file extension")
+	}
 	// Source file extension and thumbnail file extension are expected to otherwise match. JPEG and JPG aren't expected to be mixed.
 	if params.ThumbExt == params.FileExt {
 		return nil
@@ -125,172 +239,212 @@ func paramValidate(params ThumbParams) (error) {
 	return errors.New("Unsupported thumbnail file extension")
 }
 
-func generateThumbFromFile(params ThumbParams) ([]byte, error) {
-	// Initialise GCS client.
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx, storage.WithJSONReads())
-	if err != nil {
-		return nil, &ThumbError{"NewClient", err}
+// envFloat reads a float64 from the named env var, falling back to def if unset or unparsable.
+func envFloat(name string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+		return v
 	}
-	defer client.Close()
+	return def
+}
 
-	// Prepare to read source image.
-	srcObj := client.Bucket(params.Bucket).Object(params.FilePath)
-	rc, err := srcObj.NewReader(ctx)
-	if err != nil {
-		if err == storage.ErrObjectNotExist {
-			return nil, &ThumbError{"NotFound", err}
-		} else {
-			return nil, &ThumbError{"NewReader", err}
-		}
+// envInt reads an int from the named env var, falling back to def if unset or unparsable.
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return v
 	}
-	defer rc.Close()
+	return def
+}
 
-	// Retrieve source image metadata for copying to thumbnail.
-	attrs, err := srcObj.Attrs(ctx)
-	if err != nil {
-		return nil, &ThumbError{"SourceAttrs", err}
+// envDuration reads an integer number of seconds from the named env var,
+// falling back to defSeconds if unset or unparsable.
+func envDuration(name string, defSeconds int) time.Duration {
+	return time.Duration(envInt(name, defSeconds)) * time.Second
+}
+
+// checkVideoLimits rejects sources that exceed configurable duration and
+// dimension limits, so a pathological upload can't tie up ffmpeg/ffprobe
+// for an unbounded amount of time or memory.
+func checkVideoLimits(info videoprobe.Info) error {
+	maxDuration := envFloat("MAX_VIDEO_DURATION_SECONDS", defaultMaxVideoDuration)
+	if info.Duration > maxDuration {
+		return fmt.Errorf("duration %.1fs exceeds limit of %.1fs", info.Duration, maxDuration)
 	}
-	metadata := attrs.Metadata
 
-	f, err := os.CreateTemp("", "original")
-	if err != nil {
-		return nil, &ThumbError{"CreateTemp", err}
+	maxDimension := envInt("MAX_VIDEO_DIMENSION", defaultMaxVideoDimension)
+	if info.Width > maxDimension || info.Height > maxDimension {
+		return fmt.Errorf("dimensions %dx%d exceed limit of %d", info.Width, info.Height, maxDimension)
 	}
-	defer os.Remove(f.Name())
 
-	if _, err := io.Copy(f, rc); err != nil {
-		return nil, &ThumbError{"Copy", err}
-	}
-
-	// Parameters are based on Wikimedia's thumbor video plugin.
-	// https://github.com/wikimedia/operations-software-thumbor-plugins/blob/7fe573abee23729964889caf20b78349205f0f97/wikimedia_thumbor/loader/video/__init__.py#L156
-	cmd := exec.Command(
-		"ffmpeg",
-		// Input file type.
-		"-f", params.FileExt,
-		// Pass temp file name to ffmpeg.
-		"-i", f.Name(),
-		// Extract 1 frame.
-		"-vframes", "1",
-		// Disable audio.
-		"-an",
-		// Output as thumbnail.
-		"-f", "image2pipe",
-		// Set output dimensions based on desired width.
-		"-vf", "scale=" + params.Width + ":-1",
-		// Increase output quality.
-		"-qscale:v", "1", "-qmin", "1", "-qmax", "1",
+	return nil
+}
+
+// animatedThumbArgs builds the ffmpeg argv for an AnimatedThumb request: a
+// short, looping, palette-optimised clip starting at info's seek offset. If
+// info reports the source itself has only one frame, a single still frame is
+// extracted instead, skipping the looping-clip filter chain; if info reports
+// an alpha channel, the WebP output preserves it via "-pix_fmt yuva420p".
+// inputArgs supplies the input-side flags ("-f" + "-i" for a pipe, or just
+// "-i" for a file), since those differ between the two generate functions.
+func animatedThumbArgs(params ThumbParams, info videoprobe.Info, inputArgs []string) []string {
+	const (
+		animatedDuration = "3"
+		animatedFPS      = "10"
+	)
+
+	args := []string{
+		// Seek close to the probed duration's 10% mark, same as the still path.
+		"-ss", strconv.FormatFloat(info.SeekOffset(), 'f', 3, 64),
+	}
+	args = append(args, inputArgs...)
+	args = append(args, "-an") // Disable audio.
+
+	switch {
+	case info.NbFrames == 1:
+		// The source has only one frame, so the fps/palette filter chain
+		// would just repeat it; extract that single frame instead.
+		args = append(args, "-vframes", "1")
+		if params.ThumbExt == "gif" {
+			args = append(args, "-vf", "scale="+params.Width+":-1:flags=lanczos", "-f", "gif")
+		} else {
+			args = append(args, "-vf", "scale="+params.Width+":-1:flags=lanczos")
+			if info.HasAlpha {
+				args = append(args, "-pix_fmt", "yuva420p")
+			}
+			args = append(args, "-f", "webp")
+		}
+	case params.ThumbExt == "gif":
+		args = append(args,
+			"-t", animatedDuration,
+			"-vf", "fps="+animatedFPS+",scale="+params.Width+":-1:flags=lanczos,split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse",
+			"-loop", "0",
+			"-f", "gif",
+		)
+	default:
+		// WebP supports RGBA directly, so no palettegen/paletteuse pass is needed.
+		args = append(args, "-t", animatedDuration, "-vf", "fps="+animatedFPS+",scale="+params.Width+":-1:flags=lanczos")
+		if info.HasAlpha {
+			args = append(args, "-pix_fmt", "yuva420p")
+		}
+		args = append(args, "-loop", "0", "-f", "webp")
+	}
+
+	args = append(args,
 		// Disable verbose output.
 		"-nostats",
 		"-loglevel", "fatal",
 		// Use stdout as output file.
 		"pipe:1",
 	)
+	return args
+}
 
-	log.Println(cmd.Args)
-	cmd.Stderr = os.Stderr
-	out, err := cmd.Output()
+// sourceGeneration returns the current GCS generation of params' source
+// file, so a thumbCache hit can be validated against it before being served,
+// instead of keeping serving an in-process cache entry after the source has
+// been re-uploaded.
+func sourceGeneration(ctx context.Context, params ThumbParams) (string, error) {
+	client, err := storage.NewClient(ctx, storage.WithJSONReads())
 	if err != nil {
-		log.Println(out)
-		return nil, &ThumbError{"Command", err}
-	}
-
-	// Upload thumbnail to GCS.
-	thumbObj := client.Bucket(params.Bucket).Object(params.ThumbPath)
-	wc := thumbObj.NewWriter(ctx)
-	// Use the source image's metadata for the thumbnail's metadata.
-	wc.ObjectAttrs.Metadata = metadata
-
-	if _, err = io.Copy(wc, bytes.NewBuffer(out)); err != nil {
-		return out, &ThumbError{"Copy", err}
-	}
-	if err = wc.Close(); err != nil {
-		return out, &ThumbError{"Close", err}
+		return "", &ThumbError{"NewClient", err}
 	}
+	defer client.Close()
 
-	// Close temp file.
-	if err = f.Close(); err != nil {
-		return out, &ThumbError{"CloseTemp", err}
+	attrs, err := client.Bucket(params.Bucket).Object(params.FilePath).Attrs(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return "", &ThumbError{"NotFound", err}
+		}
+		return "", &ThumbError{"SourceAttrs", err}
 	}
-
-	// Send the image to the client.
-	return out, nil
+	return strconv.FormatInt(attrs.Generation, 10), nil
 }
 
-func generateThumbFromPipe(params ThumbParams) ([]byte, error) {
+func generateThumbFromFile(ctx context.Context, params ThumbParams) ([]byte, string, error) {
 	// Initialise GCS client.
-	ctx := context.Background()
 	client, err := storage.NewClient(ctx, storage.WithJSONReads())
 	if err != nil {
-		return nil, &ThumbError{"NewClient", err}
+		return nil, "", &ThumbError{"NewClient", err}
 	}
 	defer client.Close()
 
-	// Prepare to read source image.
+	// Retrieve source image metadata for copying to thumbnail, and to check
+	// whether a thumbnail for this exact source generation already exists.
 	srcObj := client.Bucket(params.Bucket).Object(params.FilePath)
+	attrs, err := srcObj.Attrs(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, "", &ThumbError{"NotFound", err}
+		}
+		return nil, "", &ThumbError{"SourceAttrs", err}
+	}
+	metadata := attrs.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[srcGenerationKey] = strconv.FormatInt(attrs.Generation, 10)
+
+	if out, hash, ok := existingThumb(ctx, client, params, metadata[srcGenerationKey]); ok {
+		return out, hash, nil
+	}
+
+	if maxSrcBytes := int64(envInt("MAX_SRC_BYTES", defaultMaxSrcBytes)); attrs.Size > maxSrcBytes {
+		return nil, "", &ThumbError{"TooLarge", fmt.Errorf("source is %d bytes, limit is %d", attrs.Size, maxSrcBytes)}
+	}
+
+	// Prepare to read source image.
 	rc, err := srcObj.NewReader(ctx)
 	if err != nil {
 		if err == storage.ErrObjectNotExist {
-			return nil, &ThumbError{"NotFound", err}
+			return nil, "", &ThumbError{"NotFound", err}
 		} else {
-			return nil, &ThumbError{"NewReader", err}
+			return nil, "", &ThumbError{"NewReader", err}
 		}
 	}
 	defer rc.Close()
 
-	// Retrieve source image metadata for copying to thumbnail.
-	attrs, err := srcObj.Attrs(ctx)
+	f, err := os.CreateTemp("", "original")
 	if err != nil {
-		return nil, &ThumbError{"SourceAttrs", err}
+		return nil, "", &ThumbError{"CreateTemp", err}
 	}
-	metadata := attrs.Metadata
+	defer os.Remove(f.Name())
 
-	// Read source image into memory.
-	data, err := io.ReadAll(rc)
+	if _, err := io.Copy(f, rc); err != nil {
+		return nil, "", &ThumbError{"Copy", err}
+	}
+
+	release, err := acquireSem(ctx, ffmpegSem)
 	if err != nil {
-		return nil, &ThumbError{"ReadAll", err}
+		return nil, "", &ThumbError{"Busy", err}
 	}
+	defer release()
 
-	// Determine handler.
-	var cmd *exec.Cmd
-	if params.MediaType == MEDIA_IMAGE {
-		// Perform thumbnailing with VIPS.
-		inOpts := ""
-		options := "strip,"
-		switch params.FileExt {
-			case "gif":
-				// For handling animated GIF.
-				inOpts = "[n=-1]"
-			case "jpeg":
-				fallthrough
-			case "jpg":
-				options += "Q=96"
-			case "png":
-				// For handling APNG.
-				//inOpts = "[n=-1]"
-			case "webp":
-				// For handling animated WEBP.
-				inOpts = "[n=-1]"
-				options += "lossless"
-		}
+	procCtx, cancel := context.WithTimeout(ctx, envDuration("PROC_TIMEOUT_SECONDS", defaultProcTimeoutSeconds))
+	defer cancel()
 
-		cmd = exec.Command("vipsthumbnail","--output=." + params.ThumbExt + "[" + options + "]","--size=" + params.Width + "x","--vips-concurrency=1","stdin" + inOpts)
-	} else if params.MediaType == MEDIA_VIDEO {
-		// Perform thumbnailing with FFmpeg.
-		fmt := params.FileExt
-		// Handle format aliases as FFmpeg does not.
-		if fmt == "ogv" {
-			fmt = "ogg"
-		}
+	// Preflight with ffprobe so we seek past a black first frame and enforce
+	// duration/dimension limits instead of trusting the URL extension.
+	info, err := videoprobe.ProbeFile(procCtx, f.Name())
+	if err != nil {
+		return nil, "", &ThumbError{"Probe", err}
+	}
+	if err := checkVideoLimits(info); err != nil {
+		return nil, "", &ThumbError{"LimitExceeded", err}
+	}
+
+	var cmd *exec.Cmd
+	if params.AnimatedThumb {
+		cmd = exec.CommandContext(procCtx, "ffmpeg", animatedThumbArgs(params, info, []string{"-i", f.Name()})...)
+	} else {
 		// Parameters are based on Wikimedia's thumbor video plugin.
 		// https://github.com/wikimedia/operations-software-thumbor-plugins/blob/7fe573abee23729964889caf20b78349205f0f97/wikimedia_thumbor/loader/video/__init__.py#L156
-		cmd = exec.Command(
+		cmd = exec.CommandContext(
+			procCtx,
 			"ffmpeg",
-			// Input file type.
-			"-f", fmt,
-			// Use stdin as input file.
-			"-i", "pipe:",
+			// Seek close to the probed duration's 10% mark before decoding, so we
+			// don't grab a black or blank first frame.
+			"-ss", strconv.FormatFloat(info.SeekOffset(), 'f', 3, 64),
+			// Pass temp file name to ffmpeg; no "-f" needed, ffmpeg sniffs the file.
+			"-i", f.Name(),
 			// Extract 1 frame.
 			"-vframes", "1",
 			// Disable audio.
@@ -307,9 +461,163 @@ func generateThumbFromPipe(params ThumbParams) ([]byte, error) {
 			// Use stdout as output file.
 			"pipe:1",
 		)
+	}
+
+	log.Println(cmd.Args)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		log.Println(out)
+		return nil, "", &ThumbError{"Command", err}
+	}
+
+	// Close temp file.
+	if err = f.Close(); err != nil {
+		return out, "", &ThumbError{"CloseTemp", err}
+	}
+
+	return uploadThumb(ctx, client, params, metadata, out)
+}
+
+func generateThumbFromPipe(ctx context.Context, params ThumbParams) ([]byte, string, error) {
+	// Initialise GCS client.
+	client, err := storage.NewClient(ctx, storage.WithJSONReads())
+	if err != nil {
+		return nil, "", &ThumbError{"NewClient", err}
+	}
+	defer client.Close()
+
+	// Retrieve source image metadata for copying to thumbnail, and to check
+	// whether a thumbnail for this exact source generation already exists.
+	srcObj := client.Bucket(params.Bucket).Object(params.FilePath)
+	attrs, err := srcObj.Attrs(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, "", &ThumbError{"NotFound", err}
+		}
+		return nil, "", &ThumbError{"SourceAttrs", err}
+	}
+	metadata := attrs.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[srcGenerationKey] = strconv.FormatInt(attrs.Generation, 10)
+
+	if out, hash, ok := existingThumb(ctx, client, params, metadata[srcGenerationKey]); ok {
+		return out, hash, nil
+	}
+
+	if maxSrcBytes := int64(envInt("MAX_SRC_BYTES", defaultMaxSrcBytes)); attrs.Size > maxSrcBytes {
+		return nil, "", &ThumbError{"TooLarge", fmt.Errorf("source is %d bytes, limit is %d", attrs.Size, maxSrcBytes)}
+	}
+
+	// Prepare to read source image.
+	rc, err := srcObj.NewReader(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, "", &ThumbError{"NotFound", err}
+		} else {
+			return nil, "", &ThumbError{"NewReader", err}
+		}
+	}
+	defer rc.Close()
+
+	// Read source image into memory.
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, "", &ThumbError{"ReadAll", err}
+	}
+
+	// Determine handler.
+	var cmd *exec.Cmd
+	if params.MediaType == MEDIA_IMAGE {
+		// Perform thumbnailing in-process; see internal/imagethumb.
+		width, err := strconv.Atoi(params.Width)
+		if err != nil {
+			return nil, "", &ThumbError{"BadWidth", err}
+		}
+
+		release, err := acquireSem(ctx, vipsSem)
+		if err != nil {
+			return nil, "", &ThumbError{"Busy", err}
+		}
+		defer release()
+
+		out, err := imagethumb.Thumbnail(bytes.NewReader(data), imagethumb.Options{
+			Format: params.ThumbExt,
+			Width:  width,
+		})
+		if err != nil {
+			return nil, "", &ThumbError{"Thumbnail", err}
+		}
+
+		return uploadThumb(ctx, client, params, metadata, out)
+	} else if params.MediaType == MEDIA_VIDEO {
+		release, err := acquireSem(ctx, ffmpegSem)
+		if err != nil {
+			return nil, "", &ThumbError{"Busy", err}
+		}
+		defer release()
+
+		procCtx, cancel := context.WithTimeout(ctx, envDuration("PROC_TIMEOUT_SECONDS", defaultProcTimeoutSeconds))
+		defer cancel()
+
+		// Preflight with ffprobe so we seek past a black first frame, detect
+		// the real container/codec instead of trusting the URL extension,
+		// and enforce duration/dimension limits.
+		info, err := videoprobe.ProbeReader(procCtx, data)
+		if err != nil {
+			return nil, "", &ThumbError{"Probe", err}
+		}
+		if err := checkVideoLimits(info); err != nil {
+			return nil, "", &ThumbError{"LimitExceeded", err}
+		}
+
+		demuxer := info.Demuxer()
+		if demuxer == "" {
+			// ffprobe couldn't identify the container; fall back to the URL
+			// extension, applying the one alias ffmpeg doesn't recognise itself.
+			demuxer = params.FileExt
+			if demuxer == "ogv" {
+				demuxer = "ogg"
+			}
+		}
+
+		if params.AnimatedThumb {
+			cmd = exec.CommandContext(procCtx, "ffmpeg", animatedThumbArgs(params, info, []string{"-f", demuxer, "-i", "pipe:"})...)
+		} else {
+			// Parameters are based on Wikimedia's thumbor video plugin.
+			// https://github.com/wikimedia/operations-software-thumbor-plugins/blob/7fe573abee23729964889caf20b78349205f0f97/wikimedia_thumbor/loader/video/__init__.py#L156
+			cmd = exec.CommandContext(
+				procCtx,
+				"ffmpeg",
+				// Input file type.
+				"-f", demuxer,
+				// Seek close to the probed duration's 10% mark before decoding, so we
+				// don't grab a black or blank first frame.
+				"-ss", strconv.FormatFloat(info.SeekOffset(), 'f', 3, 64),
+				// Use stdin as input file.
+				"-i", "pipe:",
+				// Extract 1 frame.
+				"-vframes", "1",
+				// Disable audio.
+				"-an",
+				// Output as thumbnail.
+				"-f", "image2pipe",
+				// Set output dimensions based on desired width.
+				"-vf", "scale=" + params.Width + ":-1",
+				// Increase output quality.
+				"-qscale:v", "1", "-qmin", "1", "-qmax", "1",
+				// Disable verbose output.
+				"-nostats",
+				"-loglevel", "fatal",
+				// Use stdout as output file.
+				"pipe:1",
+			)
+		}
 	} else {
 		// No handler to perform thumbnailing.
-		return nil, &ThumbError{"NoHandler", err}
+		return nil, "", &ThumbError{"NoHandler", err}
 	}
 	log.Println(cmd.Args)
 	cmd.Stdin = bytes.NewBuffer(data)
@@ -317,24 +625,73 @@ func generateThumbFromPipe(params ThumbParams) ([]byte, error) {
 	out, err := cmd.Output()
 	if err != nil {
 		log.Println(out)
-		return nil, &ThumbError{"Command", err}
+		return nil, "", &ThumbError{"Command", err}
+	}
+
+	return uploadThumb(ctx, client, params, metadata, out)
+}
+
+// existingThumb checks whether a thumbnail already exists at params.ThumbPath
+// with metadata recording the same source generation, and if so downloads
+// and returns it instead of regenerating it from scratch.
+func existingThumb(ctx context.Context, client *storage.Client, params ThumbParams, srcGeneration string) ([]byte, string, bool) {
+	thumbObj := client.Bucket(params.Bucket).Object(params.ThumbPath)
+	attrs, err := thumbObj.Attrs(ctx)
+	if err != nil || attrs.Metadata[srcGenerationKey] != srcGeneration {
+		return nil, "", false
+	}
+
+	rc, err := thumbObj.NewReader(ctx)
+	if err != nil {
+		return nil, "", false
+	}
+	defer rc.Close()
+
+	out, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, "", false
+	}
+
+	return out, attrs.Metadata["blurhash"], true
+}
+
+// uploadThumb computes a BlurHash placeholder for the generated thumbnail,
+// writes the thumbnail bytes to GCS with the BlurHash and the source
+// image's metadata attached, and returns the bytes and BlurHash to the
+// caller so the response can carry both.
+func uploadThumb(ctx context.Context, client *storage.Client, params ThumbParams, metadata map[string]string, out []byte) ([]byte, string, error) {
+	var hash string
+	if img, _, err := image.Decode(bytes.NewReader(out)); err == nil {
+		if h, err := blurhash.Encode(img); err == nil {
+			hash = h
+		} else {
+			log.Println(&ThumbError{"Blurhash", err})
+		}
+	} else {
+		log.Println(&ThumbError{"Blurhash", err})
+	}
+
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	if hash != "" {
+		metadata["blurhash"] = hash
 	}
 
-	// Upload thumbnail to GCS.
 	thumbObj := client.Bucket(params.Bucket).Object(params.ThumbPath)
 	wc := thumbObj.NewWriter(ctx)
 	// Use the source image's metadata for the thumbnail's metadata.
 	wc.ObjectAttrs.Metadata = metadata
 
-	if _, err = io.Copy(wc, bytes.NewBuffer(out)); err != nil {
-		return out, &ThumbError{"Copy", err}
+	if _, err := io.Copy(wc, bytes.NewBuffer(out)); err != nil {
+		return out, hash, &ThumbError{"Copy", err}
 	}
-	if err = wc.Close(); err != nil {
-		return out, &ThumbError{"Close", err}
+	if err := wc.Close(); err != nil {
+		return out, hash, &ThumbError{"Close", err}
 	}
 
 	// Send the image to the client.
-	return out, nil
+	return out, hash, nil
 }
 
 func thumbHandler(w http.ResponseWriter, r *http.Request) {
@@ -354,22 +711,54 @@ func thumbHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var out []byte
-	if params.FileExt == "mp4" {
-		out, err = generateThumbFromFile(params)
-	} else {
-		out, err = generateThumbFromPipe(params)
+	// Fetch the source's current GCS generation so a thumbCache hit can be
+	// validated against it instead of blindly serving a stale thumbnail
+	// after the source has been re-uploaded.
+	srcGeneration, err := sourceGeneration(r.Context(), params)
+	if err != nil {
+		if tErr, ok := err.(*ThumbError); ok && tErr.IsNotFound() {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		log.Println(err)
+		return
 	}
+
+	// Serve from the in-process cache when present; otherwise generate,
+	// coalescing concurrent requests for the same thumbnail into one call.
+	key := thumbcache.Key(params.Bucket, params.ThumbPath)
+	entry, err := thumbCache.GetOrGenerate(key, srcGeneration, func() (thumbcache.Entry, error) {
+		var out []byte
+		var hash string
+		var err error
+		if params.FileExt == "mp4" {
+			out, hash, err = generateThumbFromFile(r.Context(), params)
+		} else {
+			out, hash, err = generateThumbFromPipe(r.Context(), params)
+		}
+		return thumbcache.Entry{Data: out, BlurHash: hash, SrcGeneration: srcGeneration}, err
+	})
+	out, hash := entry.Data, entry.BlurHash
 	// Unable to generate thumbnail.
 	if err != nil {
 		if err.(*ThumbError).IsNotFound() {
 			w.WriteHeader(http.StatusNotFound)
+		} else if err.(*ThumbError).IsBusy() {
+			// Every ffmpeg/imagethumb slot is in use; ask the client to back
+			// off instead of queuing the request indefinitely.
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
 		} else if out == nil {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		log.Println(err)
 	}
 
+	if hash != "" {
+		w.Header().Set("X-Thumb-Blurhash", hash)
+	}
+
 	// Send image to client.
 	w.Write(out)
-}
\ No newline at end of file
+}
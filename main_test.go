@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestParamValidateHEICThumbnailsToJPEG(t *testing.T) {
+	base := ThumbParams{MediaType: MEDIA_IMAGE, FileExt: "heic", Width: "200"}
+
+	toJPEG := base
+	toJPEG.ThumbExt = "jpg"
+	if err := paramValidate(toJPEG); err != nil {
+		t.Errorf("heic -> jpg: got error %v, want nil", err)
+	}
+
+	toHEIC := base
+	toHEIC.ThumbExt = "heic"
+	if err := paramValidate(toHEIC); err == nil {
+		t.Errorf("heic -> heic: got nil error, want rejection (no HEIC encoder)")
+	}
+}